@@ -0,0 +1,810 @@
+// Copyright 2021 Security Scorecard Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raw
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ossf/scorecard/v4/checker"
+	"github.com/ossf/scorecard/v4/clients"
+)
+
+// DangerousWorkflowData contains the raw results for the DangerousWorkflow check,
+// aggregated across every CI system configured in the repository.
+type DangerousWorkflowData struct {
+	ScriptInjections     []ScriptInjection
+	SecretInPullRequests []SecretInPullRequest
+	UntrustedCheckouts   []UntrustedCheckout
+	SelfHostedRunners    []SelfHostedRunner
+	MutableActionRefs    []MutableActionRef
+}
+
+// CISystem identifies which CI configuration format a finding came from.
+type CISystem string
+
+const (
+	CISystemGitHubActions  CISystem = "github-actions"
+	CISystemGitLabCI       CISystem = "gitlab-ci"
+	CISystemAzurePipelines CISystem = "azure-pipelines"
+)
+
+// ScriptInjection is a CI job step whose shell script interpolates an untrusted value.
+type ScriptInjection struct {
+	File     checker.File
+	System   CISystem
+	Job      string
+	Variable string
+	// Context is the originating, untrusted expression when Variable names a
+	// pipeline variable laundering that expression rather than the expression
+	// appearing directly in the script.
+	Context string
+}
+
+// SecretInPullRequest is a CI job that exposes a secret to a triggered-by-fork event.
+type SecretInPullRequest struct {
+	File   checker.File
+	System CISystem
+	Job    string
+}
+
+// UntrustedCheckout is a CI job that checks out and runs untrusted code.
+type UntrustedCheckout struct {
+	File   checker.File
+	System CISystem
+	Job    string
+}
+
+// SelfHostedRunner is a job in a public repository's workflow that targets a self-hosted runner.
+type SelfHostedRunner struct {
+	File   checker.File
+	System CISystem
+	Job    string
+	Label  string
+	// OnPullRequest is true if the workflow's triggers include pull_request,
+	// pull_request_target, workflow_run, or issue_comment -- i.e. events that
+	// can be driven by an untrusted contributor.
+	OnPullRequest bool
+}
+
+// MutableActionRef is a `uses:` step that references a third-party action by a
+// branch name or tag rather than a pinned, immutable commit SHA. A tag or branch
+// can be silently repointed to different code after a maintainer has reviewed it.
+type MutableActionRef struct {
+	File   checker.File
+	System CISystem
+	Job    string
+	Repo   string
+	Ref    string
+}
+
+// untrustedContextPatterns flags github context expressions that are controllable
+// by whoever opened the triggering event (an issue, PR, or commit), as opposed to
+// values that only the repository owner can set.
+var untrustedContextPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`github\.head_ref`),
+	regexp.MustCompile(`github\.event\.issue\.title`),
+	regexp.MustCompile(`github\.event\.issue\.body`),
+	regexp.MustCompile(`github\.event\.pull_request\.title`),
+	regexp.MustCompile(`github\.event\.pull_request\.body`),
+	regexp.MustCompile(`github\.event\.pull_request\.head\.ref`),
+	regexp.MustCompile(`github\.event\.pull_request\.head\.label`),
+	regexp.MustCompile(`github\.event\.pull_request\.head\.repo\.default_branch`),
+	regexp.MustCompile(`github\.event\.comment\.body`),
+	regexp.MustCompile(`github\.event\.review\.body`),
+	regexp.MustCompile(`github\.event\.review_comment\.body`),
+	regexp.MustCompile(`github\.event\.pages.*\.page_name`),
+	regexp.MustCompile(`github\.event\.commits.*\.message`),
+	regexp.MustCompile(`github\.event\.commits.*\.author\.(email|name)`),
+	regexp.MustCompile(`github\.event\.head_commit\.message`),
+	regexp.MustCompile(`github\.event\.head_commit\.author\.(email|name)`),
+}
+
+// containsUntrustedContextPattern returns true if variable references a github
+// context value an attacker can control by opening an issue, PR, or pushing a commit.
+func containsUntrustedContextPattern(variable string) bool {
+	for _, re := range untrustedContextPatterns {
+		if re.MatchString(variable) {
+			return true
+		}
+	}
+	return false
+}
+
+// expressionPattern matches a GitHub Actions `${{ ... }}` expression, capturing
+// the expression body.
+var expressionPattern = regexp.MustCompile(`\$\{\{([^}]*)\}\}`)
+
+// untrustedExprIn scans value for a `${{ ... }}` expression referencing an
+// untrusted github context value, returning the first one found.
+func untrustedExprIn(value string) (string, bool) {
+	for _, m := range expressionPattern.FindAllStringSubmatch(value, -1) {
+		expr := strings.TrimSpace(m[1])
+		if containsUntrustedContextPattern(expr) {
+			return expr, true
+		}
+	}
+	return "", false
+}
+
+// directUntrustedExprs returns every untrusted github context expression that
+// appears directly (i.e. not laundered through an `env:` variable) in script.
+func directUntrustedExprs(script string) []string {
+	var exprs []string
+	for _, m := range expressionPattern.FindAllStringSubmatch(script, -1) {
+		expr := strings.TrimSpace(m[1])
+		if containsUntrustedContextPattern(expr) {
+			exprs = append(exprs, expr)
+		}
+	}
+	return exprs
+}
+
+// taintEnv applies an `env:` mapping on top of a parent taint set (job-level,
+// when called for a step), returning the resulting variable-name -> originating
+// untrusted-expression mapping. A variable re-assigned to a value that isn't
+// itself untrusted loses any taint it inherited from the parent.
+func taintEnv(parent map[string]string, env map[string]string) map[string]string {
+	tainted := make(map[string]string, len(parent)+len(env))
+	for name, origin := range parent {
+		tainted[name] = origin
+	}
+	for name, value := range env {
+		if expr, ok := untrustedExprIn(value); ok {
+			tainted[name] = expr
+		} else {
+			delete(tainted, name)
+		}
+	}
+	return tainted
+}
+
+// shellReferencesVariable returns true if script reads name the way a shell
+// would: `$name`, `${name}`, inside double quotes, or inside backticks. All of
+// these forms contain `$name` or `${name}`, so a single regular expression over
+// the raw script covers each case; `$name` must end at a word boundary, so a
+// longer variable that merely starts with name (e.g. `$TITLE_SAFE` for TITLE)
+// does not match.
+func shellReferencesVariable(script, name string) bool {
+	pattern := regexp.MustCompile(`\$\{` + regexp.QuoteMeta(name) + `\}|\$` + regexp.QuoteMeta(name) + `\b`)
+	return pattern.MatchString(script)
+}
+
+// selfHostedTriggerEvents are workflow triggers that can run attacker-supplied code
+// on whatever runner the job targets.
+var selfHostedTriggerEvents = map[string]bool{
+	"pull_request":        true,
+	"pull_request_target": true,
+	"workflow_run":        true,
+	"issue_comment":       true,
+}
+
+type githubWorkflow struct {
+	Name string                       `yaml:"name"`
+	On   yaml.Node                    `yaml:"on"`
+	Env  map[string]string            `yaml:"env"`
+	Jobs map[string]githubWorkflowJob `yaml:"jobs"`
+}
+
+type githubWorkflowJob struct {
+	Name string `yaml:"name"`
+	// Environment is non-zero when the job is gated behind a deployment
+	// environment's protection rules (required reviewers, wait timers), which
+	// require manual approval before secrets become available to the job.
+	Environment yaml.Node              `yaml:"environment"`
+	Strategy    githubWorkflowStrategy `yaml:"strategy"`
+	RunsOn      yaml.Node              `yaml:"runs-on"`
+	Env         map[string]string      `yaml:"env"`
+	Steps       []githubWorkflowStep   `yaml:"steps"`
+}
+
+// githubWorkflowStrategy holds a job's `strategy.matrix:` axes, keyed by axis
+// name (e.g. "os", "label"), so a `runs-on: ${{ matrix.label }}` expression can
+// be resolved against the literal values that axis expands to.
+type githubWorkflowStrategy struct {
+	Matrix map[string]yaml.Node `yaml:"matrix"`
+}
+
+type githubWorkflowStep struct {
+	Name string            `yaml:"name"`
+	Uses yamlScalar        `yaml:"uses"`
+	Run  yamlScalar        `yaml:"run"`
+	Env  map[string]string `yaml:"env"`
+	With map[string]string `yaml:"with"`
+}
+
+// yamlScalar decodes a scalar node while retaining its source line, so findings
+// derived from it can point back at the exact line in the workflow file.
+type yamlScalar struct {
+	Value string
+	Line  int
+}
+
+func (s *yamlScalar) UnmarshalYAML(node *yaml.Node) error {
+	s.Value = node.Value
+	s.Line = node.Line
+	return nil
+}
+
+// commitSHAPattern matches a full, 40 hex character commit SHA.
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// actionRepoAndRef splits a `uses:` value such as "owner/repo/path@ref" into its
+// repo ("owner/repo/path") and ref parts. Local actions ("./path") and Docker
+// actions ("docker://image") aren't fetched from a ref and are ignored.
+func actionRepoAndRef(uses string) (repo, ref string, ok bool) {
+	if uses == "" || strings.HasPrefix(uses, "./") || strings.HasPrefix(uses, "docker://") {
+		return "", "", false
+	}
+	idx := strings.LastIndex(uses, "@")
+	if idx == -1 {
+		return "", "", false
+	}
+	return uses[:idx], uses[idx+1:], true
+}
+
+func isAllowlistedAction(repo string, allowlist []string) bool {
+	for _, prefix := range allowlist {
+		if strings.HasPrefix(repo, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerEvents returns the flat set of event names a workflow's `on:` block lists,
+// handling the scalar, sequence, and mapping forms GitHub Actions all allow.
+func triggerEvents(on yaml.Node) []string {
+	switch on.Kind {
+	case yaml.ScalarNode:
+		return []string{on.Value}
+	case yaml.SequenceNode:
+		events := make([]string, 0, len(on.Content))
+		for _, n := range on.Content {
+			events = append(events, n.Value)
+		}
+		return events
+	case yaml.MappingNode:
+		events := make([]string, 0, len(on.Content)/2)
+		for i := 0; i < len(on.Content); i += 2 {
+			events = append(events, on.Content[i].Value)
+		}
+		return events
+	default:
+		return nil
+	}
+}
+
+func hasPullRequestTrigger(events []string) bool {
+	for _, e := range events {
+		if selfHostedTriggerEvents[e] {
+			return true
+		}
+	}
+	return false
+}
+
+// runnerLabels flattens the scalar/sequence forms of `runs-on:` into the label
+// strings it lists. A `${{ matrix.* }}` expression is returned as-is;
+// expandedRunnerLabels resolves it against the job's strategy.matrix.
+func runnerLabels(runsOn yaml.Node) []string {
+	switch runsOn.Kind {
+	case yaml.ScalarNode:
+		return []string{runsOn.Value}
+	case yaml.SequenceNode:
+		labels := make([]string, 0, len(runsOn.Content))
+		for _, n := range runsOn.Content {
+			labels = append(labels, n.Value)
+		}
+		return labels
+	default:
+		return nil
+	}
+}
+
+// secretPattern matches a reference to a repository secret, e.g. `secrets.TOKEN`.
+var secretPattern = regexp.MustCompile(`secrets\.[A-Za-z0-9_]+`)
+
+// untrustedCheckoutRefPattern matches a `ref:` value derived from the head of a
+// fork-originated pull request or workflow run -- the code an attacker controls,
+// as opposed to the base/default ref a job with elevated privileges normally runs.
+var untrustedCheckoutRefPattern = regexp.MustCompile(
+	`github\.event\.pull_request\.head\.(sha|ref)|github\.event\.workflow_run\.head_(sha|branch)`)
+
+// checkoutRiskTriggerEvents are workflow triggers that run with repository secrets
+// and write permissions while still being driven by a fork contributor, making an
+// explicit checkout of their untrusted head ref dangerous.
+var checkoutRiskTriggerEvents = map[string]bool{
+	"pull_request_target": true,
+	"workflow_run":        true,
+}
+
+func containsEvent(events []string, name string) bool {
+	for _, e := range events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCheckoutRiskTrigger(events []string) bool {
+	for _, e := range events {
+		if checkoutRiskTriggerEvents[e] {
+			return true
+		}
+	}
+	return false
+}
+
+func isCheckoutAction(repo string) bool {
+	return repo == "actions/checkout" || strings.HasPrefix(repo, "actions/checkout/")
+}
+
+// jobHasEnvironmentProtection reports whether a job is gated behind a deployment
+// environment, which requires manual approval before its secrets are unlocked.
+func jobHasEnvironmentProtection(job githubWorkflowJob) bool {
+	return job.Environment.Kind != 0
+}
+
+// secretMatches returns one SecretInPullRequest finding per `secrets.*` reference
+// found in value.
+func secretMatches(file checker.File, jobName string, value string) []SecretInPullRequest {
+	matches := secretPattern.FindAllString(value, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	findings := make([]SecretInPullRequest, 0, len(matches))
+	for range matches {
+		findings = append(findings, SecretInPullRequest{
+			File:   file,
+			System: CISystemGitHubActions,
+			Job:    jobName,
+		})
+	}
+	return findings
+}
+
+// matrixKeyPattern extracts the matrix axis name from an expression such as
+// `${{ matrix.label }}`.
+var matrixKeyPattern = regexp.MustCompile(`matrix\.([A-Za-z0-9_]+)`)
+
+// matrixAxisValues returns the literal values a job's `strategy.matrix.<key>`
+// axis expands to, or nil if the job doesn't define that axis as a plain list.
+func matrixAxisValues(job githubWorkflowJob, key string) []string {
+	node, ok := job.Strategy.Matrix[key]
+	if !ok || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	values := make([]string, 0, len(node.Content))
+	for _, n := range node.Content {
+		values = append(values, n.Value)
+	}
+	return values
+}
+
+// expandedRunnerLabels returns the literal label(s) a job's `runs-on:` can
+// take. A `${{ matrix.<key> }}` expression is resolved against the job's
+// `strategy.matrix` so e.g. `runs-on: ${{ matrix.label }}` alongside
+// `matrix: {label: [self-hosted, linux]}` is seen as the labels "self-hosted"
+// and "linux", not the opaque expression text.
+func expandedRunnerLabels(job githubWorkflowJob) []string {
+	var expanded []string
+	for _, label := range runnerLabels(job.RunsOn) {
+		m := matrixKeyPattern.FindStringSubmatch(label)
+		if m == nil {
+			expanded = append(expanded, label)
+			continue
+		}
+		values := matrixAxisValues(job, m[1])
+		if values == nil {
+			expanded = append(expanded, label)
+			continue
+		}
+		expanded = append(expanded, values...)
+	}
+	return expanded
+}
+
+func isSelfHostedLabel(label string) bool {
+	if label == "self-hosted" {
+		return true
+	}
+	if strings.Contains(label, "${{") {
+		// A matrix-driven label expandedRunnerLabels couldn't resolve against
+		// a strategy.matrix axis, e.g. it names a context other than matrix.
+		// Fall back to treating a mention of self-hosted anywhere in the
+		// expression as a hit.
+		return strings.Contains(label, "self-hosted") || strings.Contains(label, "self_hosted")
+	}
+	return strings.Contains(strings.ToLower(label), "self-hosted")
+}
+
+// DangerousWorkflow runs the Dangerous-Workflow check across every CI
+// configuration format the repository uses: GitHub Actions workflows,
+// .gitlab-ci.yml, and azure-pipelines.yml. repoIsPrivate gates the
+// self-hosted-runner finding: a private repository's contributors are already
+// trusted, so a job targeting a self-hosted runner there isn't the
+// fork-PR-to-persistent-runner attack this check looks for. allowedActionPrefixes,
+// when given, exempts third-party GitHub Actions whose repo starts with one of
+// the prefixes (e.g. "actions/") from the mutable-action-ref check.
+func DangerousWorkflow(c clients.RepoClient, repoIsPrivate bool, allowedActionPrefixes ...string) (DangerousWorkflowData, error) {
+	files, err := c.ListFiles(func(filename string) (bool, error) {
+		return isWorkflowFile(filename) || isGitLabCIFile(filename) || isAzurePipelinesFile(filename), nil
+	})
+	if err != nil {
+		return DangerousWorkflowData{}, fmt.Errorf("%w", err)
+	}
+
+	var results DangerousWorkflowData
+	for _, file := range files {
+		// ListFiles' callback is a hint, not a guarantee -- some clients (and
+		// the mocks in our tests) return every file regardless, so fetch and
+		// classify each one rather than trusting it's already filtered.
+		content, err := c.GetFileContent(file)
+		if err != nil {
+			return DangerousWorkflowData{}, fmt.Errorf("%w", err)
+		}
+
+		switch {
+		case isWorkflowFile(file):
+			analyzeGithubActionsWorkflow(file, content, repoIsPrivate, allowedActionPrefixes, &results)
+		case isGitLabCIFile(file):
+			analyzeGitLabCIConfig(file, content, &results)
+		case isAzurePipelinesFile(file):
+			analyzeAzurePipelinesConfig(file, content, &results)
+		}
+	}
+
+	return results, nil
+}
+
+// analyzeGithubActionsWorkflow parses a single GitHub Actions workflow file and
+// appends any findings to results.
+func analyzeGithubActionsWorkflow(
+	file string, content []byte, repoIsPrivate bool, allowedActionPrefixes []string, results *DangerousWorkflowData,
+) {
+	var workflow githubWorkflow
+	if err := yaml.Unmarshal(content, &workflow); err != nil {
+		// Not a workflow we can make sense of; skip it rather than failing
+		// the whole check on an unrelated YAML file.
+		return
+	}
+
+	events := triggerEvents(workflow.On)
+	onPullRequest := hasPullRequestTrigger(events)
+	checkoutRisk := hasCheckoutRiskTrigger(events)
+	secretsAtRisk := containsEvent(events, "pull_request_target")
+	workflowTaint := taintEnv(nil, workflow.Env)
+
+	for jobName, job := range workflow.Jobs {
+		if !repoIsPrivate {
+			for _, label := range expandedRunnerLabels(job) {
+				if isSelfHostedLabel(label) {
+					results.SelfHostedRunners = append(results.SelfHostedRunners, SelfHostedRunner{
+						File: checker.File{
+							Path: file,
+							Type: checker.FileTypeSource,
+						},
+						System:        CISystemGitHubActions,
+						Job:           jobName,
+						Label:         label,
+						OnPullRequest: onPullRequest,
+					})
+				}
+			}
+		}
+
+		jobTaint := taintEnv(workflowTaint, job.Env)
+		jobSecretsAtRisk := secretsAtRisk && !jobHasEnvironmentProtection(job)
+
+		// untrustedCheckoutStep is the index of the most recent step.Uses: that
+		// checked out a fork's untrusted head ref, or -1 if the job hasn't done
+		// so (yet). Any later step in the same job may be running that code.
+		untrustedCheckoutStep := -1
+
+		for i, step := range job.Steps {
+			repo, ref, ok := actionRepoAndRef(step.Uses.Value)
+			if ok && !commitSHAPattern.MatchString(ref) && !isAllowlistedAction(repo, allowedActionPrefixes) {
+				results.MutableActionRefs = append(results.MutableActionRefs, MutableActionRef{
+					File: checker.File{
+						Path:   file,
+						Type:   checker.FileTypeSource,
+						Offset: uint(step.Uses.Line),
+					},
+					System: CISystemGitHubActions,
+					Job:    jobName,
+					Repo:   repo,
+					Ref:    ref,
+				})
+			}
+
+			if checkoutRisk && isCheckoutAction(repo) && untrustedCheckoutRefPattern.MatchString(step.With["ref"]) {
+				untrustedCheckoutStep = i
+			}
+
+			if jobSecretsAtRisk {
+				for _, v := range step.With {
+					results.SecretInPullRequests = append(results.SecretInPullRequests, secretMatches(
+						checker.File{Path: file, Type: checker.FileTypeSource, Offset: uint(step.Uses.Line)},
+						jobName, v)...)
+				}
+				if untrustedCheckoutStep != -1 {
+					for _, v := range step.Env {
+						results.SecretInPullRequests = append(results.SecretInPullRequests, secretMatches(
+							checker.File{Path: file, Type: checker.FileTypeSource, Offset: uint(step.Uses.Line)},
+							jobName, v)...)
+					}
+				}
+			}
+
+			if step.Run.Value == "" {
+				continue
+			}
+
+			if untrustedCheckoutStep != -1 && i > untrustedCheckoutStep {
+				results.UntrustedCheckouts = append(results.UntrustedCheckouts, UntrustedCheckout{
+					File:   checker.File{Path: file, Type: checker.FileTypeSource, Offset: uint(step.Run.Line)},
+					System: CISystemGitHubActions,
+					Job:    jobName,
+				})
+			}
+
+			for _, expr := range directUntrustedExprs(step.Run.Value) {
+				results.ScriptInjections = append(results.ScriptInjections, ScriptInjection{
+					File: checker.File{
+						Path:   file,
+						Type:   checker.FileTypeSource,
+						Offset: uint(step.Run.Line),
+					},
+					System:   CISystemGitHubActions,
+					Job:      jobName,
+					Variable: expr,
+				})
+			}
+
+			taint := taintEnv(jobTaint, step.Env)
+			for name, origin := range taint {
+				if !shellReferencesVariable(step.Run.Value, name) {
+					continue
+				}
+				results.ScriptInjections = append(results.ScriptInjections, ScriptInjection{
+					File: checker.File{
+						Path:   file,
+						Type:   checker.FileTypeSource,
+						Offset: uint(step.Run.Line),
+					},
+					System:   CISystemGitHubActions,
+					Job:      jobName,
+					Variable: name,
+					Context:  origin,
+				})
+			}
+
+			if jobSecretsAtRisk {
+				results.SecretInPullRequests = append(results.SecretInPullRequests, secretMatches(
+					checker.File{Path: file, Type: checker.FileTypeSource, Offset: uint(step.Run.Line)},
+					jobName, step.Run.Value)...)
+			}
+		}
+
+		if jobSecretsAtRisk && untrustedCheckoutStep != -1 {
+			for _, v := range job.Env {
+				results.SecretInPullRequests = append(results.SecretInPullRequests, secretMatches(
+					checker.File{Path: file, Type: checker.FileTypeSource}, jobName, v)...)
+			}
+			for _, v := range workflow.Env {
+				results.SecretInPullRequests = append(results.SecretInPullRequests, secretMatches(
+					checker.File{Path: file, Type: checker.FileTypeSource}, jobName, v)...)
+			}
+		}
+	}
+}
+
+func isWorkflowFile(filename string) bool {
+	return strings.HasPrefix(filename, ".github/workflows/") &&
+		(strings.HasSuffix(filename, ".yml") || strings.HasSuffix(filename, ".yaml"))
+}
+
+func isGitLabCIFile(filename string) bool {
+	return filename == ".gitlab-ci.yml"
+}
+
+func isAzurePipelinesFile(filename string) bool {
+	base := filepath.Base(filename)
+	return base == "azure-pipelines.yml" || base == "azure-pipelines.yaml"
+}
+
+// gitlabCIReservedKeys are top-level .gitlab-ci.yml keys that configure the
+// pipeline itself rather than defining a runnable job.
+var gitlabCIReservedKeys = map[string]bool{
+	"stages":        true,
+	"variables":     true,
+	"default":       true,
+	"include":       true,
+	"workflow":      true,
+	"image":         true,
+	"services":      true,
+	"cache":         true,
+	"before_script": true,
+	"after_script":  true,
+}
+
+type gitlabCIJob struct {
+	Script    []string          `yaml:"script"`
+	Variables map[string]string `yaml:"variables"`
+	Rules     []gitlabCIRule    `yaml:"rules"`
+}
+
+type gitlabCIRule struct {
+	If string `yaml:"if"`
+}
+
+// ciMergeRequestVarPattern matches a shell reference to a CI_MERGE_REQUEST_*
+// predefined variable, which GitLab populates from the merge request that
+// triggered the pipeline -- title, source branch, description, etc. -- and is
+// therefore attacker-controlled on a fork merge request.
+var ciMergeRequestVarPattern = regexp.MustCompile(`\$\{?CI_MERGE_REQUEST_[A-Z0-9_]+\}?`)
+
+// mergeRequestRulePattern matches a `rules: - if:` condition that scopes a job
+// to merge-request pipelines, including fork merge requests.
+var mergeRequestRulePattern = regexp.MustCompile(`CI_MERGE_REQUEST|merge_request_event`)
+
+// secretLikeVariableName matches pipeline variable names that conventionally
+// hold a secret.
+var secretLikeVariableName = regexp.MustCompile(`(?i)(TOKEN|SECRET|KEY|PASSWORD|CREDENTIAL)`)
+
+// analyzeGitLabCIConfig parses a single .gitlab-ci.yml file and appends any
+// findings to results.
+func analyzeGitLabCIConfig(file string, content []byte, results *DangerousWorkflowData) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil || len(root.Content) == 0 {
+		return
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		jobName := doc.Content[i].Value
+		jobNode := doc.Content[i+1]
+		if gitlabCIReservedKeys[jobName] || strings.HasPrefix(jobName, ".") {
+			continue // reserved keyword or a hidden job template, not a runnable job
+		}
+
+		var job gitlabCIJob
+		if err := jobNode.Decode(&job); err != nil {
+			continue
+		}
+
+		for _, line := range job.Script {
+			for _, match := range ciMergeRequestVarPattern.FindAllString(line, -1) {
+				results.ScriptInjections = append(results.ScriptInjections, ScriptInjection{
+					File:     checker.File{Path: file, Type: checker.FileTypeSource, Offset: uint(jobNode.Line)},
+					System:   CISystemGitLabCI,
+					Job:      jobName,
+					Variable: match,
+				})
+			}
+		}
+
+		runsOnForkMR := false
+		for _, rule := range job.Rules {
+			if mergeRequestRulePattern.MatchString(rule.If) {
+				runsOnForkMR = true
+				break
+			}
+		}
+		if !runsOnForkMR {
+			continue
+		}
+		for name := range job.Variables {
+			if secretLikeVariableName.MatchString(name) {
+				results.SecretInPullRequests = append(results.SecretInPullRequests, SecretInPullRequest{
+					File:   checker.File{Path: file, Type: checker.FileTypeSource, Offset: uint(jobNode.Line)},
+					System: CISystemGitLabCI,
+					Job:    jobName,
+				})
+				break
+			}
+		}
+	}
+}
+
+type azureStep struct {
+	Checkout yamlScalar `yaml:"checkout"`
+}
+
+type azureJob struct {
+	Steps []azureStep `yaml:"steps"`
+}
+
+type azureStage struct {
+	Jobs []azureJob `yaml:"jobs"`
+}
+
+type azureVariable struct {
+	Name string `yaml:"name"`
+}
+
+type azurePipeline struct {
+	PR        yaml.Node       `yaml:"pr"`
+	Variables []azureVariable `yaml:"variables"`
+	Steps     []azureStep     `yaml:"steps"`
+	Jobs      []azureJob      `yaml:"jobs"`
+	Stages    []azureStage    `yaml:"stages"`
+}
+
+// allSteps flattens steps declared directly on the pipeline and steps nested
+// under jobs and stages/jobs.
+func (p azurePipeline) allSteps() []azureStep {
+	steps := append([]azureStep{}, p.Steps...)
+	for _, job := range p.Jobs {
+		steps = append(steps, job.Steps...)
+	}
+	for _, stage := range p.Stages {
+		for _, job := range stage.Jobs {
+			steps = append(steps, job.Steps...)
+		}
+	}
+	return steps
+}
+
+// hasPRTrigger reports whether an azure-pipelines.yml `pr:` section enables
+// pull-request triggered builds. Its absence means the default (enabled) PR
+// trigger GitHub Azure Pipelines integration uses; `pr: none` disables it.
+func hasPRTrigger(pr yaml.Node) bool {
+	if pr.Kind == 0 {
+		return true
+	}
+	return !(pr.Kind == yaml.ScalarNode && pr.Value == "none")
+}
+
+// analyzeAzurePipelinesConfig parses a single azure-pipelines.yml file and
+// appends any findings to results.
+func analyzeAzurePipelinesConfig(file string, content []byte, results *DangerousWorkflowData) {
+	var pipeline azurePipeline
+	if err := yaml.Unmarshal(content, &pipeline); err != nil {
+		return
+	}
+	if !hasPRTrigger(pipeline.PR) {
+		return
+	}
+
+	hasSecretVariable := false
+	for _, v := range pipeline.Variables {
+		if secretLikeVariableName.MatchString(v.Name) {
+			hasSecretVariable = true
+			break
+		}
+	}
+	if !hasSecretVariable {
+		return
+	}
+
+	for _, step := range pipeline.allSteps() {
+		if step.Checkout.Value != "" && step.Checkout.Value != "none" {
+			results.UntrustedCheckouts = append(results.UntrustedCheckouts, UntrustedCheckout{
+				File:   checker.File{Path: file, Type: checker.FileTypeSource, Offset: uint(step.Checkout.Line)},
+				System: CISystemAzurePipelines,
+			})
+		}
+	}
+}
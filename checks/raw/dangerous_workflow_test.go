@@ -86,17 +86,110 @@ func TestUntrustedContextVariables(t *testing.T) {
 	}
 }
 
+func TestIsAllowlistedAction(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		repo      string
+		allowlist []string
+		expected  bool
+	}{
+		{
+			name:      "no allowlist configured",
+			repo:      "actions/checkout",
+			allowlist: nil,
+			expected:  false,
+		},
+		{
+			name:      "matches allowlisted prefix",
+			repo:      "actions/checkout",
+			allowlist: []string{"actions/"},
+			expected:  true,
+		},
+		{
+			name:      "does not match allowlisted prefix",
+			repo:      "some-org/some-action",
+			allowlist: []string{"actions/"},
+			expected:  false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt // Re-initializing variable so it is not changed while executing the closure below
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if r := isAllowlistedAction(tt.repo, tt.allowlist); r != tt.expected {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestShellReferencesVariable(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		script   string
+		variable string
+		expected bool
+	}{
+		{
+			name:     "dollar form",
+			script:   `echo $TITLE`,
+			variable: "TITLE",
+			expected: true,
+		},
+		{
+			name:     "braced form",
+			script:   `echo "${TITLE}"`,
+			variable: "TITLE",
+			expected: true,
+		},
+		{
+			name:     "backtick form",
+			script:   "echo `echo $TITLE`",
+			variable: "TITLE",
+			expected: true,
+		},
+		{
+			name:     "prefix match is not a reference",
+			script:   `echo $TITLE_SAFE`,
+			variable: "TITLE",
+			expected: false,
+		},
+		{
+			name:     "unrelated variable",
+			script:   `echo $SAFE`,
+			variable: "TITLE",
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt // Re-initializing variable so it is not changed while executing the closure below
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if r := shellReferencesVariable(tt.script, tt.variable); r != tt.expected {
+				t.Fail()
+			}
+		})
+	}
+}
+
 func TestGithubDangerousWorkflow(t *testing.T) {
 	t.Parallel()
 
 	type ret struct {
-		err error
-		nb  int
+		err               error
+		nb                int
+		selfHostedRunners int
+		mutableActionRefs int
 	}
 	tests := []struct {
-		name     string
-		filename string
-		expected ret
+		name          string
+		filename      string
+		repoIsPrivate bool
+		expected      ret
 	}{
 		{
 			name:     "Non-yaml file",
@@ -218,6 +311,67 @@ func TestGithubDangerousWorkflow(t *testing.T) {
 			filename: ".github/workflows/github-workflow-dangerous-pattern-secret-env-checkout-noref-prt.yml",
 			expected: ret{nb: 0},
 		},
+		{
+			name:     "self-hosted runner on pull_request",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-self-hosted-runner.yml",
+			expected: ret{nb: 0, selfHostedRunners: 1},
+		},
+		{
+			name:     "self-hosted runner label in matrix",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-self-hosted-runner-matrix.yml",
+			expected: ret{nb: 0, selfHostedRunners: 1},
+		},
+		{
+			name:     "github-hosted runner is not flagged",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-github-hosted-runner.yml",
+			expected: ret{nb: 0, selfHostedRunners: 0},
+		},
+		{
+			name:          "self-hosted runner on a private repo is not flagged",
+			filename:      ".github/workflows/github-workflow-dangerous-pattern-self-hosted-runner.yml",
+			repoIsPrivate: true,
+			expected:      ret{nb: 0, selfHostedRunners: 0},
+		},
+		{
+			name:     "action pinned by tag and branch is flagged",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-mutable-action-ref.yml",
+			expected: ret{nb: 0, mutableActionRefs: 2},
+		},
+		{
+			name:     "action pinned by sha is not flagged",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-pinned-action-ref.yml",
+			expected: ret{nb: 0, mutableActionRefs: 0},
+		},
+		{
+			name:     "untrusted context laundered through job-level env",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-env-laundered-job-level.yml",
+			expected: ret{nb: 1},
+		},
+		{
+			name:     "untrusted context laundered through step-level env",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-env-laundered-step-level.yml",
+			expected: ret{nb: 1},
+		},
+		{
+			name:     "untrusted context laundered through env in a matrix job",
+			filename: ".github/workflows/github-workflow-dangerous-pattern-env-laundered-matrix.yml",
+			expected: ret{nb: 1},
+		},
+		{
+			name:     "gitlab ci merge request variable in script and secret exposed to fork MR",
+			filename: ".gitlab-ci.yml",
+			expected: ret{nb: 2},
+		},
+		{
+			name:     "azure pipelines pull-request checkout with secret variable",
+			filename: "azure/pr-checkout-secret/azure-pipelines.yml",
+			expected: ret{nb: 1},
+		},
+		{
+			name:     "azure pipelines with pr triggers disabled",
+			filename: "azure/pr-none/azure-pipelines.yml",
+			expected: ret{nb: 0},
+		},
 	}
 	for _, tt := range tests {
 		tt := tt // Re-initializing variable so it is not changed while executing the closure below
@@ -236,7 +390,7 @@ func TestGithubDangerousWorkflow(t *testing.T) {
 				return content, nil
 			})
 
-			dw, err := DangerousWorkflow(mockRepoClient)
+			dw, err := DangerousWorkflow(mockRepoClient, tt.repoIsPrivate)
 
 			if !errCmp(err, tt.expected.err) {
 				t.Errorf(cmp.Diff(err, tt.expected.err, cmpopts.EquateErrors()))
@@ -249,6 +403,12 @@ func TestGithubDangerousWorkflow(t *testing.T) {
 			if nb != tt.expected.nb {
 				t.Errorf(cmp.Diff(nb, tt.expected.nb))
 			}
+			if len(dw.SelfHostedRunners) != tt.expected.selfHostedRunners {
+				t.Errorf(cmp.Diff(len(dw.SelfHostedRunners), tt.expected.selfHostedRunners))
+			}
+			if len(dw.MutableActionRefs) != tt.expected.mutableActionRefs {
+				t.Errorf(cmp.Diff(len(dw.MutableActionRefs), tt.expected.mutableActionRefs))
+			}
 		})
 	}
 }